@@ -19,66 +19,118 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"log/slog"
 	"regexp"
 	"strconv"
-	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
+	"github.com/gofiber/fiber/v3/middleware/compress"
+	"github.com/gofiber/fiber/v3/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sugawarayuuta/sonnet"
-	"github.com/valkey-io/valkey-go"
 )
 
-func main() {
-	client, err := valkey.NewClient(valkey.ClientOption{InitAddress: []string{"127.0.0.1:6379"}})
+var customSlugPattern = regexp.MustCompile(`^![a-zA-Z0-9_-]{2,32}$`)
+
+// App wires the configured Store, middleware chain, and routes into a ready
+// to serve *fiber.App.
+func App(cfg Config) (*fiber.App, error) {
+	store, err := NewStore(cfg)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	ctx := context.Background()
+
+	SetJWTSecret(cfg.JwtSecret)
 
 	app := fiber.New(fiber.Config{
 		JSONEncoder: sonnet.Marshal,
 		JSONDecoder: sonnet.Unmarshal,
 	})
 
+	app.Hooks().OnPreShutdown(store.Close)
+
+	app.Use(recover.New())
+	app.Use(CountErrors)
+	app.Use(SlogLogger(slog.Default()))
+	app.Use(compress.New())
+
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+
 	app.Get("/", func(c fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"bang!": "1.1.0",
 		})
 	})
-	app.Post("/new", func(c fiber.Ctx) error {
+	app.Post("/register", RegisterHandler(store))
+	app.Post("/login", LoginHandler(store))
+	app.Get("/mine", RequireAuth, MineHandler(store))
+
+	app.Post("/new", RequireAuth, func(c fiber.Ctx) error {
 		redirect := c.Query("url")
 		if redirect == "" {
 			return fiber.NewError(fiber.StatusBadRequest, "missing url query parameter")
 		}
 
-		if !ValidateUrl(redirect) {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid url, please add http:// or https://")
+		if err := ValidateURL(c.Context(), cfg, redirect); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
-		slug, err := RandStr(5)
-		slug = fmt.Sprintf("!%v", slug)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to generate random string")
+		owner := c.Locals("user").(string)
+
+		slug := c.Query("slug")
+		if slug != "" {
+			if !customSlugPattern.MatchString(slug) {
+				return fiber.NewError(fiber.StatusBadRequest, "custom slug must match ^![a-zA-Z0-9_-]{2,32}$")
+			}
+			taken, err := store.Exists(c.Context(), slug)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to check slug availability")
+			}
+			if taken {
+				return fiber.NewError(fiber.StatusConflict, "slug already taken")
+			}
+		} else {
+			random, err := RandStr(5)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "failed to generate random string")
+			}
+			slug = fmt.Sprintf("!%v", random)
 		}
 
-		key, err := RandStr(64)
+		var ttl time.Duration
+		if raw := c.Query("ttl"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid ttl duration")
+			}
+			ttl = parsed
+		}
 
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to generate admin key")
+		var maxClicks int64
+		if raw := c.Query("max_clicks"); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid max_clicks")
+			}
+			maxClicks = parsed
 		}
 
-		err = client.Do(ctx, client.B().Rpush().Key(slug).Element(redirect).Element(key).Element(fmt.Sprint(0)).Build()).Error()
-		if err != nil {
+		if err := store.Create(c.Context(), slug, redirect, owner, ttl, maxClicks); err != nil {
+			if errors.Is(err, ErrSlugExists) {
+				return fiber.NewError(fiber.StatusConflict, "slug already taken")
+			}
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to register redirect")
 		}
 
+		createdTotal.Inc()
+
 		return c.JSON(fiber.Map{
 			"slug": slug,
-			"key":  key,
 		})
 	})
 	app.Get("/:slug", func(c fiber.Ctx) error {
@@ -87,102 +139,98 @@ func main() {
 			return fiber.NewError(fiber.StatusBadRequest, "missing slug")
 		}
 
-		val, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(0).Build()).ToString()
-		if err != nil || val == "" {
+		redirect, err := store.Lookup(c.Context(), slug)
+		if errors.Is(err, ErrGone) {
+			return fiber.NewError(fiber.StatusGone, "redirect reached its max_clicks limit")
+		}
+		if err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "failed to retrieve redirect")
 		}
 
-		go func() {
-			val, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(2).Build()).ToString()
-			if err != nil {
-				log.Println("Failed to get clicks counter for" + slug)
-				return
-			}
-			valint, _ := strconv.Atoi(val)
+		// Re-run the same guard /new applied at creation time: a host that
+		// resolved publicly back then may have since been repointed at an
+		// internal address (DNS rebinding, or just an updated A record).
+		if err := ValidateURL(c.Context(), cfg, redirect.Url); err != nil {
+			return fiber.NewError(fiber.StatusForbidden, "redirect target is no longer permitted")
+		}
 
-			err = client.Do(ctx, client.B().Lset().Key(slug).Index(2).Element(fmt.Sprint(valint+1)).Build()).Error()
-			if err != nil {
-				log.Println("Failed to increment counter for" + slug)
-				return
+		redirectsTotal.WithLabelValues(slug).Inc()
+
+		go func() {
+			if err := store.RecordClick(context.Background(), slug); err != nil {
+				log.Println("Failed to record click for " + slug)
 			}
 		}()
 
-		return c.Redirect().To(val)
+		return c.Redirect().To(redirect.Url)
 	})
-	app.Get("/clicks/:slug", func(c fiber.Ctx) error {
+	app.Get("/clicks/:slug", RequireAuth, func(c fiber.Ctx) error {
 		slug := c.Params("slug")
-		key := c.Query("key")
 		if slug == "" || slug == "!" {
 			return fiber.NewError(fiber.StatusBadRequest, "missing slug")
 		}
-		if key == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "missing key from query params")
-		}
 
-		field, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(1).Build()).ToString()
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "failed to check admin key")
+		redirect, err := store.Lookup(c.Context(), slug)
+		if err != nil && !errors.Is(err, ErrGone) {
+			return fiber.NewError(fiber.StatusBadRequest, "failed to retrieve redirect")
 		}
-		if field != key {
+		if redirect.Owner != c.Locals("user").(string) {
 			return fiber.NewError(fiber.StatusUnauthorized)
 		}
 
-		val, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(2).Build()).ToString()
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to get statistics")
-		}
-
-		return c.SendString(val)
+		return c.SendString(fmt.Sprint(redirect.Clicks))
 	})
-	app.Delete("/:slug", func(c fiber.Ctx) error {
+	app.Get("/stats/:slug", RequireAuth, func(c fiber.Ctx) error {
 		slug := c.Params("slug")
-		key := c.Query("key")
 		if slug == "" || slug == "!" {
 			return fiber.NewError(fiber.StatusBadRequest, "missing slug")
 		}
-		if key == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "missing key from query params")
-		}
 
-		val, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(0).Build()).ToString()
-		if err != nil || val == "" {
+		redirect, err := store.Lookup(c.Context(), slug)
+		if err != nil && !errors.Is(err, ErrGone) {
 			return fiber.NewError(fiber.StatusBadRequest, "failed to retrieve redirect")
 		}
+		if redirect.Owner != c.Locals("user").(string) {
+			return fiber.NewError(fiber.StatusUnauthorized)
+		}
 
-		field, err := client.Do(ctx, client.B().Lindex().Key(slug).Index(1).Build()).ToString()
+		window := 24 * time.Hour
+		if raw := c.Query("window"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid window duration")
+			}
+			window = parsed
+		}
+
+		stats, err := store.Stats(c.Context(), slug, window)
 		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "failed to check admin key")
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to compute statistics")
 		}
 
-		if field != key {
-			return fiber.NewError(fiber.StatusUnauthorized)
+		return c.JSON(stats)
+	})
+	app.Delete("/:slug", RequireAuth, func(c fiber.Ctx) error {
+		slug := c.Params("slug")
+		if slug == "" || slug == "!" {
+			return fiber.NewError(fiber.StatusBadRequest, "missing slug")
 		}
 
-		err = client.Do(ctx, client.B().Del().Key(slug).Build()).Error()
-		if err != nil || val == "" {
+		err := store.Delete(c.Context(), slug, c.Locals("user").(string))
+		if errors.Is(err, ErrNotFound) {
+			return fiber.NewError(fiber.StatusBadRequest, "failed to retrieve redirect")
+		}
+		if errors.Is(err, ErrForbidden) {
+			return fiber.NewError(fiber.StatusUnauthorized)
+		}
+		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "failed to delete redirect")
 		}
 
 		return c.SendStatus(fiber.StatusOK)
 	})
 
-	go func() {
-		if err := app.Listen(":8080"); err != nil {
-			log.Panic(err)
-		}
-	}()
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	_ = <-c
-
-	log.Println("Shutting down...")
-	_ = app.Shutdown()
-
-	log.Println("Cleaning up...")
-	client.Close()
-
-	log.Println("Successful shutdown.")
+	return app, nil
 }
 
 const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -200,8 +248,3 @@ func RandStr(n int) (string, error) {
 
 	return string(bytes), nil
 }
-
-func ValidateUrl(str string) bool {
-	r, _ := regexp.Compile(`^http:\/\/[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$|^https:\/\/[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return r.MatchString(str)
-}
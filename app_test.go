@@ -0,0 +1,185 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// newTestApp builds an App against the in-memory Store, so handler tests
+// don't need a running Valkey or SQLite instance.
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+
+	app, err := App(Config{Storage: "memory", JwtSecret: "test-secret"})
+	if err != nil {
+		t.Fatalf("App: %v", err)
+	}
+
+	return app
+}
+
+// doJSON sends body as a JSON request and decodes a JSON response, if out is
+// non-nil.
+func doJSON(t *testing.T, app *fiber.App, method, path, token string, body, out any) *http.Response {
+	t.Helper()
+
+	var reqBody *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode response body: %v", err)
+		}
+	}
+
+	return resp
+}
+
+func registerAndLogin(t *testing.T, app *fiber.App, username, password string) string {
+	t.Helper()
+
+	creds := map[string]string{"username": username, "password": password}
+
+	if resp := doJSON(t, app, http.MethodPost, "/register", "", creds, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: got status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	if resp := doJSON(t, app, http.MethodPost, "/login", "", creds, &login); resp.StatusCode != http.StatusOK {
+		t.Fatalf("login: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	return login.Token
+}
+
+func TestRegisterLoginNewRedirect(t *testing.T) {
+	app := newTestApp(t)
+	token := registerAndLogin(t, app, "alice", "hunter2")
+
+	var created struct {
+		Slug string `json:"slug"`
+	}
+	resp := doJSON(t, app, http.MethodPost, "/new?url=https://example.com/", token, nil, &created)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /new: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if created.Slug == "" {
+		t.Fatalf("POST /new: got empty slug")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+created.Slug, nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("GET /%s: %v", created.Slug, err)
+	}
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("GET /%s: got status %d, want %d", created.Slug, resp.StatusCode, http.StatusSeeOther)
+	}
+	if location := resp.Header.Get("Location"); location != "https://example.com/" {
+		t.Fatalf("GET /%s: got Location %q, want %q", created.Slug, location, "https://example.com/")
+	}
+}
+
+func TestNewRequiresAuth(t *testing.T) {
+	app := newTestApp(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/new?url=https://example.com/", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("POST /new: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST /new without a token: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestRegisterDuplicateUsernameConflicts(t *testing.T) {
+	app := newTestApp(t)
+	creds := map[string]string{"username": "bob", "password": "hunter2"}
+
+	if resp := doJSON(t, app, http.MethodPost, "/register", "", creds, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first register: got status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	if resp := doJSON(t, app, http.MethodPost, "/register", "", creds, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("second register: got status %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestCustomSlugTakenConflicts(t *testing.T) {
+	app := newTestApp(t)
+	token := registerAndLogin(t, app, "carol", "hunter2")
+
+	resp := doJSON(t, app, http.MethodPost, "/new?url=https://example.com/&slug=!mine", token, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first POST /new: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	resp = doJSON(t, app, http.MethodPost, "/new?url=https://example.org/&slug=!mine", token, nil, nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("second POST /new with the same slug: got status %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestClicksRequiresOwnership(t *testing.T) {
+	app := newTestApp(t)
+	ownerToken := registerAndLogin(t, app, "dave", "hunter2")
+	otherToken := registerAndLogin(t, app, "erin", "hunter2")
+
+	var created struct {
+		Slug string `json:"slug"`
+	}
+	doJSON(t, app, http.MethodPost, "/new?url=https://example.com/", ownerToken, nil, &created)
+
+	req := httptest.NewRequest(http.MethodGet, "/clicks/"+created.Slug, nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("GET /clicks/%s: %v", created.Slug, err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET /clicks/%s as non-owner: got status %d, want %d", created.Slug, resp.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argonMemory      = 64 * 1024
+	argonIterations  = 3
+	argonParallelism = 2
+	argonKeyLen      = 32
+	argonSaltLen     = 16
+)
+
+var jwtSigningSecret []byte
+
+// SetJWTSecret sets the key used to sign and verify JWTs. It must be called
+// before the server starts accepting logins or authenticated requests.
+func SetJWTSecret(secret string) {
+	jwtSigningSecret = []byte(secret)
+}
+
+// HashPassword derives an Argon2id hash for password and encodes it, along with
+// its parameters and salt, into the standard `$argon2id$...` form.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argonIterations, argonMemory, argonParallelism, argonKeyLen)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		argonMemory,
+		argonIterations,
+		argonParallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// VerifyPassword recomputes the Argon2id hash of password using the parameters
+// and salt encoded in encoded, then compares it in constant time.
+func VerifyPassword(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, fmt.Errorf("malformed password hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, err
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(hash)))
+
+	return subtle.ConstantTimeCompare(hash, candidate) == 1, nil
+}
+
+// IssueToken signs a JWT identifying username as the subject, valid for a week.
+func IssueToken(username string) (string, error) {
+	if len(jwtSigningSecret) == 0 {
+		return "", fmt.Errorf("JWT secret is not set")
+	}
+
+	claims := jwt.RegisteredClaims{
+		Subject:   username,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSigningSecret)
+}
+
+// RequireAuth is a Fiber handler guarding routes that require a logged-in
+// user. On success it stores the authenticated username under the "user"
+// local for downstream handlers.
+func RequireAuth(c fiber.Ctx) error {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return fiber.NewError(fiber.StatusUnauthorized, "missing bearer token")
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSigningSecret, nil
+	})
+	if err != nil || !token.Valid || claims.Subject == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired token")
+	}
+
+	c.Locals("user", claims.Subject)
+	return c.Next()
+}
+
+func RegisterHandler(store Store) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.Bind().Body(&body); err != nil || body.Username == "" || body.Password == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "missing username or password")
+		}
+
+		hash, err := HashPassword(body.Password)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to hash password")
+		}
+
+		err = store.CreateUser(c.Context(), body.Username, hash)
+		if errors.Is(err, ErrUserExists) {
+			return fiber.NewError(fiber.StatusConflict, "user already exists")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to create user")
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	}
+}
+
+func LoginHandler(store Store) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.Bind().Body(&body); err != nil || body.Username == "" || body.Password == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "missing username or password")
+		}
+
+		hash, err := store.UserPasswordHash(c.Context(), body.Username)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid username or password")
+		}
+
+		ok, err := VerifyPassword(body.Password, hash)
+		if err != nil || !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid username or password")
+		}
+
+		token, err := IssueToken(body.Username)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to issue token")
+		}
+
+		return c.JSON(fiber.Map{
+			"token": token,
+		})
+	}
+}
+
+func MineHandler(store Store) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		username := c.Locals("user").(string)
+
+		redirects, err := store.List(c.Context(), username)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to list redirects")
+		}
+
+		type entry struct {
+			Slug string `json:"slug"`
+			Url  string `json:"url"`
+		}
+
+		entries := make([]entry, 0, len(redirects))
+		for _, redirect := range redirects {
+			entries = append(entries, entry{Slug: redirect.Slug, Url: redirect.Url})
+		}
+
+		return c.JSON(entries)
+	}
+}
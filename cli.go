@@ -0,0 +1,325 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	storageBackend string
+	cliValkeyAddr  string
+	cliSqlitePath  string
+)
+
+// newRootCmd builds the bang CLI: serving the redirector is one subcommand
+// among user and slug management.
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "bang",
+		Short: "A minimal, self-hosted URL shortener",
+	}
+
+	root.PersistentFlags().StringVar(&storageBackend, "storage", "valkey", "storage backend: valkey, sqlite, or memory")
+	root.PersistentFlags().StringVar(&cliValkeyAddr, "valkey-addr", "127.0.0.1:6379", "address of the Valkey instance")
+	root.PersistentFlags().StringVar(&cliSqlitePath, "sqlite-path", "bang.db", "path to the SQLite database file")
+
+	root.AddCommand(newServeCmd())
+	root.AddCommand(newUserCmd())
+	root.AddCommand(newSlugCmd())
+	root.AddCommand(newGcCmd())
+
+	return root
+}
+
+// openStore builds the Store selected by the --storage/--valkey-addr/--sqlite-path flags.
+func openStore() (Store, error) {
+	return NewStore(Config{
+		Storage:    storageBackend,
+		ValkeyAddr: cliValkeyAddr,
+		SqlitePath: cliSqlitePath,
+	})
+}
+
+func newServeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Run the bang HTTP server",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := parseConfig()
+			if err != nil {
+				return err
+			}
+
+			app, err := App(cfg)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				if err := app.Listen(cfg.ListenAddr); err != nil {
+					log.Panic(err)
+				}
+			}()
+
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+
+			log.Println("Shutting down...")
+			return app.Shutdown()
+		},
+	}
+}
+
+func newUserCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Manage bang users",
+	}
+
+	cmd.AddCommand(newUserCreateCmd(), newUserDeleteCmd(), newUserPasswdCmd())
+
+	return cmd
+}
+
+func newUserCreateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "create <username>",
+		Short: "Create a new user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+
+			hash, err := HashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			return store.CreateUser(context.Background(), args[0], hash)
+		},
+	}
+}
+
+func newUserDeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <username>",
+		Short: "Delete a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			return store.DeleteUser(context.Background(), args[0])
+		},
+	}
+}
+
+func newUserPasswdCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "passwd <username>",
+		Short: "Change a user's password",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+
+			hash, err := HashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			return store.SetPasswordHash(context.Background(), args[0], hash)
+		},
+	}
+}
+
+// readPassword prompts for a password twice on the terminal, without echoing
+// it, and returns it once both entries match.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+
+	return string(password), nil
+}
+
+func newSlugCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "slug",
+		Short: "Inspect and migrate redirects",
+	}
+
+	cmd.AddCommand(newSlugListCmd(), newSlugExportCmd(), newSlugImportCmd())
+
+	return cmd
+}
+
+func newSlugListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all redirects",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			redirects, err := store.All(context.Background())
+			if err != nil {
+				return err
+			}
+
+			for _, redirect := range redirects {
+				fmt.Printf("%s\t%s\t%s\t%d\n", redirect.Slug, redirect.Url, redirect.Owner, redirect.Clicks)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newSlugExportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "export",
+		Short: "Dump all redirects as newline-delimited JSON",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			redirects, err := store.All(context.Background())
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			for _, redirect := range redirects {
+				if err := encoder.Encode(redirect); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func newSlugImportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import",
+		Short: "Restore redirects from newline-delimited JSON on stdin",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			ctx := context.Background()
+
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				var redirect Redirect
+				if err := json.Unmarshal(scanner.Bytes(), &redirect); err != nil {
+					return err
+				}
+
+				if err := store.Restore(ctx, redirect); err != nil {
+					return err
+				}
+			}
+
+			return scanner.Err()
+		},
+	}
+}
+
+func newGcCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "gc",
+		Short: "Remove malformed redirects left behind by interrupted writes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			ctx := context.Background()
+
+			slugs, err := store.Malformed(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, slug := range slugs {
+				if err := store.Purge(ctx, slug); err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("removed %d malformed redirect(s)\n", len(slugs))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ardanlabs/conf/v3"
+)
+
+// Config is bang's runtime configuration, parsed from environment variables
+// (and equivalent --flags) by ardanlabs/conf.
+type Config struct {
+	Storage    string `conf:"default:valkey,help:storage backend: valkey, sqlite, or memory"`
+	ListenAddr string `conf:"default::8080"`
+	BaseUrl    string `conf:"default:http://localhost:8080"`
+	JwtSecret  string `conf:"mask"`
+	ValkeyAddr string `conf:"default:127.0.0.1:6379"`
+	SqlitePath string `conf:"default:bang.db"`
+
+	BlockPrivateHosts   bool     `conf:"default:false,help:reject redirect targets that resolve to loopback/private/link-local addresses"`
+	AllowedHostSuffixes []string `conf:"help:if set, only allow redirect targets whose host matches one of these suffixes"`
+	DeniedHostSuffixes  []string `conf:"help:reject redirect targets whose host matches one of these suffixes"`
+}
+
+// parseConfig reads Config from the environment, printing usage and exiting
+// if help was requested.
+func parseConfig() (Config, error) {
+	var cfg Config
+
+	help, err := conf.Parse("", &cfg)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			fmt.Fprintln(os.Stdout, help)
+			os.Exit(0)
+		}
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	redirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bang_redirects_total",
+		Help: "Total number of redirects served, labeled by slug.",
+	}, []string{"slug"})
+
+	createdTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bang_created_total",
+		Help: "Total number of redirects created.",
+	})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bang_errors_total",
+		Help: "Total number of error responses, labeled by status code.",
+	}, []string{"code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bang_request_duration_seconds",
+		Help:    "Request latency in seconds, labeled by method, path, and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(redirectsTotal, createdTotal, errorsTotal, requestDuration)
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// CountErrors increments bang_errors_total for every response with a 4xx or
+// 5xx status code, labeled by the numeric status code.
+func CountErrors(c fiber.Ctx) error {
+	err := c.Next()
+
+	if status := c.Response().StatusCode(); status >= fiber.StatusBadRequest {
+		errorsTotal.WithLabelValues(fmt.Sprint(status)).Inc()
+	}
+
+	return err
+}
+
+// SlogLogger returns a Fiber handler that logs every request as structured
+// JSON via the given slog.Logger once the handler chain has returned.
+func SlogLogger(logger *slog.Logger) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		elapsed := time.Since(start)
+
+		status := c.Response().StatusCode()
+		requestDuration.WithLabelValues(c.Method(), c.Route().Path, fmt.Sprint(status)).Observe(elapsed.Seconds())
+
+		logger.Info("request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", status,
+			"latency_ms", elapsed.Milliseconds(),
+			"ip", c.IP(),
+		)
+
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrForbidden  = errors.New("not the owner of this redirect")
+	ErrUserExists = errors.New("user already exists")
+	ErrSlugExists = errors.New("slug already exists")
+	ErrGone       = errors.New("redirect reached its max_clicks limit")
+)
+
+// Redirect is a short link as seen by the rest of the application, regardless
+// of which Store backend it came from.
+type Redirect struct {
+	Slug      string
+	Url       string
+	Owner     string
+	Clicks    int64
+	MaxClicks *int64
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// ClickStats buckets a redirect's clicks into fixed-size windows for the
+// `/stats/:slug` endpoint, keyed by each bucket's RFC3339 start time.
+type ClickStats struct {
+	PerMinute map[string]int64 `json:"per_minute"`
+	PerHour   map[string]int64 `json:"per_hour"`
+	PerDay    map[string]int64 `json:"per_day"`
+}
+
+// bucketClicks groups click timestamps within window into per-minute,
+// per-hour, and per-day counts. Shared by every Store implementation so the
+// bucketing behaves identically regardless of backend.
+func bucketClicks(clicks []time.Time, window time.Duration) ClickStats {
+	cutoff := time.Now().Add(-window)
+
+	stats := ClickStats{
+		PerMinute: map[string]int64{},
+		PerHour:   map[string]int64{},
+		PerDay:    map[string]int64{},
+	}
+
+	for _, t := range clicks {
+		if t.Before(cutoff) {
+			continue
+		}
+
+		stats.PerMinute[t.Truncate(time.Minute).Format(time.RFC3339)]++
+		stats.PerHour[t.Truncate(time.Hour).Format(time.RFC3339)]++
+		stats.PerDay[t.Truncate(24*time.Hour).Format(time.RFC3339)]++
+	}
+
+	return stats
+}
+
+// Store abstracts the persistence backend for redirects and users, so
+// handlers don't need to know whether they're talking to Valkey, SQLite, or
+// an in-memory map.
+type Store interface {
+	// Create registers a new redirect. It must fail atomically with
+	// ErrSlugExists if slug is already taken, since callers typically check
+	// Exists first and a plain check-then-write would race.
+	Create(ctx context.Context, slug, url, owner string, ttl time.Duration, maxClicks int64) error
+	Exists(ctx context.Context, slug string) (bool, error)
+	Lookup(ctx context.Context, slug string) (Redirect, error)
+	RecordClick(ctx context.Context, slug string) error
+	Stats(ctx context.Context, slug string, window time.Duration) (ClickStats, error)
+	Delete(ctx context.Context, slug, owner string) error
+	List(ctx context.Context, owner string) ([]Redirect, error)
+
+	// All and Restore back the `bang slug export`/`import` commands, which
+	// operate across every redirect rather than a single owner's.
+	All(ctx context.Context) ([]Redirect, error)
+	Restore(ctx context.Context, redirect Redirect) error
+
+	// Malformed and Purge back `bang gc`. Malformed reports slugs whose
+	// stored data is incomplete (e.g. left behind by an interrupted write)
+	// and so can't be represented as a well-formed Redirect; Purge removes
+	// one of those slugs outright, bypassing the owner check Delete does,
+	// since a malformed entry may have no recoverable owner.
+	Malformed(ctx context.Context) ([]string, error)
+	Purge(ctx context.Context, slug string) error
+
+	CreateUser(ctx context.Context, username, passwordHash string) error
+	UserPasswordHash(ctx context.Context, username string) (string, error)
+	SetPasswordHash(ctx context.Context, username, passwordHash string) error
+	DeleteUser(ctx context.Context, username string) error
+
+	Close() error
+}
+
+// NewStore builds the Store selected by cfg.Storage.
+func NewStore(cfg Config) (Store, error) {
+	switch cfg.Storage {
+	case "valkey":
+		return NewValkeyStore(cfg.ValkeyAddr)
+	case "sqlite":
+		return NewSQLiteStore(cfg.SqlitePath)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
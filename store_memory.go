@@ -0,0 +1,231 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store backed by plain maps. It's meant for
+// tests and local experimentation; nothing is persisted across restarts.
+type MemoryStore struct {
+	mu        sync.Mutex
+	redirects map[string]Redirect
+	clicks    map[string][]time.Time
+	users     map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		redirects: make(map[string]Redirect),
+		clicks:    make(map[string][]time.Time),
+		users:     make(map[string]string),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, slug, url, owner string, ttl time.Duration, maxClicks int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.redirects[slug]; ok {
+		return ErrSlugExists
+	}
+
+	redirect := Redirect{Slug: slug, Url: url, Owner: owner, CreatedAt: time.Now()}
+	if ttl > 0 {
+		expires := redirect.CreatedAt.Add(ttl)
+		redirect.ExpiresAt = &expires
+	}
+	if maxClicks > 0 {
+		redirect.MaxClicks = &maxClicks
+	}
+
+	s.redirects[slug] = redirect
+	return nil
+}
+
+func (s *MemoryStore) Exists(ctx context.Context, slug string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.redirects[slug]
+	return ok, nil
+}
+
+func (s *MemoryStore) Lookup(ctx context.Context, slug string) (Redirect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redirect, ok := s.redirects[slug]
+	if !ok {
+		return Redirect{}, ErrNotFound
+	}
+	if redirect.ExpiresAt != nil && redirect.ExpiresAt.Before(time.Now()) {
+		delete(s.redirects, slug)
+		delete(s.clicks, slug)
+		return Redirect{}, ErrNotFound
+	}
+
+	redirect.Clicks = int64(len(s.clicks[slug]))
+	if redirect.MaxClicks != nil && redirect.Clicks >= *redirect.MaxClicks {
+		return redirect, ErrGone
+	}
+
+	return redirect, nil
+}
+
+func (s *MemoryStore) RecordClick(ctx context.Context, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.redirects[slug]; !ok {
+		return ErrNotFound
+	}
+
+	s.clicks[slug] = append(s.clicks[slug], time.Now())
+	return nil
+}
+
+func (s *MemoryStore) Stats(ctx context.Context, slug string, window time.Duration) (ClickStats, error) {
+	s.mu.Lock()
+	clicks := append([]time.Time(nil), s.clicks[slug]...)
+	s.mu.Unlock()
+
+	return bucketClicks(clicks, window), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, slug, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redirect, ok := s.redirects[slug]
+	if !ok {
+		return ErrNotFound
+	}
+	if redirect.Owner != owner {
+		return ErrForbidden
+	}
+
+	delete(s.redirects, slug)
+	delete(s.clicks, slug)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, owner string) ([]Redirect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var redirects []Redirect
+	for _, redirect := range s.redirects {
+		if redirect.Owner == owner {
+			redirect.Clicks = int64(len(s.clicks[redirect.Slug]))
+			redirects = append(redirects, redirect)
+		}
+	}
+
+	return redirects, nil
+}
+
+func (s *MemoryStore) All(ctx context.Context) ([]Redirect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redirects := make([]Redirect, 0, len(s.redirects))
+	for _, redirect := range s.redirects {
+		redirect.Clicks = int64(len(s.clicks[redirect.Slug]))
+		redirects = append(redirects, redirect)
+	}
+
+	return redirects, nil
+}
+
+// Malformed always reports none: a Redirect stored in s.redirects can only
+// exist with the fields Create or Restore gave it.
+func (s *MemoryStore) Malformed(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// Purge force-removes slug and its click history without checking ownership.
+func (s *MemoryStore) Purge(ctx context.Context, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.redirects, slug)
+	delete(s.clicks, slug)
+	return nil
+}
+
+// Restore re-creates a previously exported redirect. Its per-click history
+// isn't exported, so the restored lifetime click count starts back at zero.
+func (s *MemoryStore) Restore(ctx context.Context, redirect Redirect) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redirect.Clicks = 0
+	s.redirects[redirect.Slug] = redirect
+	return nil
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, username, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return ErrUserExists
+	}
+
+	s.users[username] = passwordHash
+	return nil
+}
+
+func (s *MemoryStore) UserPasswordHash(ctx context.Context, username string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, ok := s.users[username]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return hash, nil
+}
+
+func (s *MemoryStore) SetPasswordHash(ctx context.Context, username, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return ErrNotFound
+	}
+
+	s.users[username] = passwordHash
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, username)
+	return nil
+}
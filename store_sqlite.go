@@ -0,0 +1,322 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStore implements Store on a single SQLite database file, so bang can
+// run as a single binary without a Valkey instance. Individual clicks are
+// logged to a separate table so `/stats/:slug` can bucket them by time.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS redirects (
+			slug       TEXT PRIMARY KEY,
+			url        TEXT NOT NULL,
+			owner      TEXT NOT NULL,
+			clicks     INTEGER NOT NULL DEFAULT 0,
+			max_clicks INTEGER,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS redirects_owner_idx ON redirects(owner);
+
+		CREATE TABLE IF NOT EXISTS clicks (
+			slug       TEXT NOT NULL,
+			clicked_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS clicks_slug_idx ON clicks(slug);
+
+		CREATE TABLE IF NOT EXISTS users (
+			username TEXT PRIMARY KEY,
+			password TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Create(ctx context.Context, slug, url, owner string, ttl time.Duration, maxClicks int64) error {
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		expiresAt = sql.NullInt64{Int64: time.Now().Add(ttl).Unix(), Valid: true}
+	}
+
+	var maxClicksVal sql.NullInt64
+	if maxClicks > 0 {
+		maxClicksVal = sql.NullInt64{Int64: maxClicks, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO redirects (slug, url, owner, clicks, max_clicks, created_at, expires_at) VALUES (?, ?, ?, 0, ?, ?, ?)`,
+		slug, url, owner, maxClicksVal, time.Now().Unix(), expiresAt,
+	)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT {
+		return ErrSlugExists
+	}
+	return err
+}
+
+func (s *SQLiteStore) Exists(ctx context.Context, slug string) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM redirects WHERE slug = ?`, slug).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLiteStore) Lookup(ctx context.Context, slug string) (Redirect, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT slug, url, owner, clicks, max_clicks, created_at, expires_at FROM redirects WHERE slug = ?`, slug)
+
+	redirect, err := scanRedirect(row.Scan)
+	if err != nil {
+		return Redirect{}, err
+	}
+
+	if redirect.ExpiresAt != nil && redirect.ExpiresAt.Before(time.Now()) {
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM redirects WHERE slug = ?`, slug)
+		return Redirect{}, ErrNotFound
+	}
+
+	if redirect.MaxClicks != nil && redirect.Clicks >= *redirect.MaxClicks {
+		return redirect, ErrGone
+	}
+
+	return redirect, nil
+}
+
+func (s *SQLiteStore) RecordClick(ctx context.Context, slug string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE redirects SET clicks = clicks + 1 WHERE slug = ?`, slug)
+	if err != nil {
+		return err
+	}
+	if err := requireRowAffected(result); err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO clicks (slug, clicked_at) VALUES (?, ?)`, slug, time.Now().Unix())
+	return err
+}
+
+func (s *SQLiteStore) Stats(ctx context.Context, slug string, window time.Duration) (ClickStats, error) {
+	cutoff := time.Now().Add(-window).Unix()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT clicked_at FROM clicks WHERE slug = ? AND clicked_at >= ?`, slug, cutoff)
+	if err != nil {
+		return ClickStats{}, err
+	}
+	defer rows.Close()
+
+	var clicks []time.Time
+	for rows.Next() {
+		var clickedAt int64
+		if err := rows.Scan(&clickedAt); err != nil {
+			return ClickStats{}, err
+		}
+		clicks = append(clicks, time.Unix(clickedAt, 0))
+	}
+
+	return bucketClicks(clicks, window), rows.Err()
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, slug, owner string) error {
+	redirect, err := s.Lookup(ctx, slug)
+	if err != nil && err != ErrGone {
+		return err
+	}
+	if redirect.Owner != owner {
+		return ErrForbidden
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM clicks WHERE slug = ?`, slug); err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `DELETE FROM redirects WHERE slug = ?`, slug)
+	return err
+}
+
+func (s *SQLiteStore) List(ctx context.Context, owner string) ([]Redirect, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT slug, url, owner, clicks, max_clicks, created_at, expires_at FROM redirects WHERE owner = ?`, owner)
+	if err != nil {
+		return nil, err
+	}
+	return scanRedirects(rows)
+}
+
+func (s *SQLiteStore) All(ctx context.Context) ([]Redirect, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT slug, url, owner, clicks, max_clicks, created_at, expires_at FROM redirects`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRedirects(rows)
+}
+
+// Malformed always reports none: url/owner are NOT NULL in the schema, so a
+// row can't exist without them.
+func (s *SQLiteStore) Malformed(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// Purge force-removes slug's row and click history without checking
+// ownership.
+func (s *SQLiteStore) Purge(ctx context.Context, slug string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM clicks WHERE slug = ?`, slug); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM redirects WHERE slug = ?`, slug)
+	return err
+}
+
+// Restore re-creates a previously exported redirect, including its lifetime
+// click count, but its per-click history isn't exported so `/stats` starts
+// out empty for it.
+func (s *SQLiteStore) Restore(ctx context.Context, redirect Redirect) error {
+	createdAt := redirect.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	var expiresAt sql.NullInt64
+	if redirect.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: redirect.ExpiresAt.Unix(), Valid: true}
+	}
+
+	var maxClicks sql.NullInt64
+	if redirect.MaxClicks != nil {
+		maxClicks = sql.NullInt64{Int64: *redirect.MaxClicks, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO redirects (slug, url, owner, clicks, max_clicks, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		redirect.Slug, redirect.Url, redirect.Owner, redirect.Clicks, maxClicks, createdAt.Unix(), expiresAt,
+	)
+	return err
+}
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, username, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO users (username, password) VALUES (?, ?)`, username, passwordHash)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (s *SQLiteStore) UserPasswordHash(ctx context.Context, username string) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT password FROM users WHERE username = ?`, username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return hash, err
+}
+
+func (s *SQLiteStore) SetPasswordHash(ctx context.Context, username, passwordHash string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE users SET password = ? WHERE username = ?`, passwordHash, username)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result)
+}
+
+func (s *SQLiteStore) DeleteUser(ctx context.Context, username string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE username = ?`, username)
+	return err
+}
+
+func scanRedirect(scan func(dest ...any) error) (Redirect, error) {
+	var redirect Redirect
+	var maxClicks sql.NullInt64
+	var createdAt int64
+	var expiresAt sql.NullInt64
+
+	if err := scan(&redirect.Slug, &redirect.Url, &redirect.Owner, &redirect.Clicks, &maxClicks, &createdAt, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return Redirect{}, ErrNotFound
+		}
+		return Redirect{}, err
+	}
+
+	if maxClicks.Valid {
+		redirect.MaxClicks = &maxClicks.Int64
+	}
+
+	redirect.CreatedAt = time.Unix(createdAt, 0)
+	if expiresAt.Valid {
+		expires := time.Unix(expiresAt.Int64, 0)
+		redirect.ExpiresAt = &expires
+	}
+
+	return redirect, nil
+}
+
+func scanRedirects(rows *sql.Rows) ([]Redirect, error) {
+	defer rows.Close()
+
+	var redirects []Redirect
+	for rows.Next() {
+		redirect, err := scanRedirect(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		redirects = append(redirects, redirect)
+	}
+
+	return redirects, rows.Err()
+}
+
+func requireRowAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
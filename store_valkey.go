@@ -0,0 +1,305 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// ValkeyStore implements Store on the list-per-slug/set-per-owner layout bang
+// has always used: `!slug` is a 3-element list of [url, owner, max_clicks],
+// `owner:<name>:slugs` is a set of that owner's slugs, and `clicks:<slug>` is
+// a sorted set of individual clicks, scored by the unix-minute they landed
+// in, so lifetime totals and time-bucketed stats both derive from it.
+type ValkeyStore struct {
+	client valkey.Client
+}
+
+func NewValkeyStore(addr string) (*ValkeyStore, error) {
+	client, err := valkey.NewClient(valkey.ClientOption{InitAddress: []string{addr}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValkeyStore{client: client}, nil
+}
+
+func (s *ValkeyStore) Close() error {
+	s.client.Close()
+	return nil
+}
+
+func userKey(username string) string {
+	return fmt.Sprintf("user:%v", username)
+}
+
+func ownerKey(username string) string {
+	return fmt.Sprintf("owner:%v:slugs", username)
+}
+
+func clicksKey(slug string) string {
+	return fmt.Sprintf("clicks:%v", slug)
+}
+
+// createIfNotExistsScript atomically creates the slug's list only if it
+// doesn't already exist, so two concurrent creates of the same custom slug
+// can't both RPUSH onto it and corrupt the list.
+const createIfNotExistsScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('RPUSH', KEYS[1], ARGV[1], ARGV[2], ARGV[3])
+return 1
+`
+
+func (s *ValkeyStore) Create(ctx context.Context, slug, url, owner string, ttl time.Duration, maxClicks int64) error {
+	created, err := s.client.Do(ctx, s.client.B().Eval().Script(createIfNotExistsScript).Numkeys(1).
+		Key(slug).Arg(url, owner, fmt.Sprint(maxClicks)).Build()).ToInt64()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return ErrSlugExists
+	}
+
+	if err := s.client.Do(ctx, s.client.B().Sadd().Key(ownerKey(owner)).Member(slug).Build()).Error(); err != nil {
+		return err
+	}
+
+	if ttl > 0 {
+		return s.client.Do(ctx, s.client.B().Expire().Key(slug).Seconds(int64(ttl.Seconds())).Build()).Error()
+	}
+
+	return nil
+}
+
+func (s *ValkeyStore) Exists(ctx context.Context, slug string) (bool, error) {
+	n, err := s.client.Do(ctx, s.client.B().Exists().Key(slug).Build()).ToInt64()
+	if err != nil {
+		return false, err
+	}
+
+	return n != 0, nil
+}
+
+func (s *ValkeyStore) Lookup(ctx context.Context, slug string) (Redirect, error) {
+	fields, err := s.client.Do(ctx, s.client.B().Lrange().Key(slug).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil || len(fields) < 3 {
+		return Redirect{}, ErrNotFound
+	}
+
+	clicks, err := s.client.Do(ctx, s.client.B().Zcard().Key(clicksKey(slug)).Build()).ToInt64()
+	if err != nil {
+		clicks = 0
+	}
+
+	redirect := Redirect{Slug: slug, Url: fields[0], Owner: fields[1], Clicks: clicks}
+
+	if maxClicks, err := strconv.ParseInt(fields[2], 10, 64); err == nil && maxClicks > 0 {
+		redirect.MaxClicks = &maxClicks
+		if redirect.Clicks >= maxClicks {
+			return redirect, ErrGone
+		}
+	}
+
+	return redirect, nil
+}
+
+func (s *ValkeyStore) RecordClick(ctx context.Context, slug string) error {
+	now := time.Now()
+	minuteBucket := float64(now.Truncate(time.Minute).Unix())
+	member := strconv.FormatInt(now.UnixNano(), 10)
+
+	return s.client.Do(ctx, s.client.B().Zadd().Key(clicksKey(slug)).ScoreMember().ScoreMember(minuteBucket, member).Build()).Error()
+}
+
+func (s *ValkeyStore) Stats(ctx context.Context, slug string, window time.Duration) (ClickStats, error) {
+	cutoff := time.Now().Add(-window).Truncate(time.Minute).Unix()
+
+	members, err := s.client.Do(ctx, s.client.B().Zrangebyscore().Key(clicksKey(slug)).Min(fmt.Sprint(cutoff)).Max("+inf").Build()).AsStrSlice()
+	if err != nil {
+		return ClickStats{}, err
+	}
+
+	clicks := make([]time.Time, 0, len(members))
+	for _, member := range members {
+		nanos, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		clicks = append(clicks, time.Unix(0, nanos))
+	}
+
+	return bucketClicks(clicks, window), nil
+}
+
+func (s *ValkeyStore) Delete(ctx context.Context, slug, owner string) error {
+	redirect, err := s.Lookup(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if redirect.Owner != owner {
+		return ErrForbidden
+	}
+
+	if err := s.client.Do(ctx, s.client.B().Del().Key(slug).Key(clicksKey(slug)).Build()).Error(); err != nil {
+		return err
+	}
+
+	return s.client.Do(ctx, s.client.B().Srem().Key(ownerKey(owner)).Member(slug).Build()).Error()
+}
+
+func (s *ValkeyStore) List(ctx context.Context, owner string) ([]Redirect, error) {
+	slugs, err := s.client.Do(ctx, s.client.B().Smembers().Key(ownerKey(owner)).Build()).AsStrSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := make([]Redirect, 0, len(slugs))
+	for _, slug := range slugs {
+		redirect, err := s.Lookup(ctx, slug)
+		if err != nil {
+			continue
+		}
+		redirects = append(redirects, redirect)
+	}
+
+	return redirects, nil
+}
+
+func (s *ValkeyStore) All(ctx context.Context) ([]Redirect, error) {
+	slugs, err := s.scanSlugs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := make([]Redirect, 0, len(slugs))
+	for _, slug := range slugs {
+		redirect, err := s.Lookup(ctx, slug)
+		if err != nil {
+			continue
+		}
+		redirects = append(redirects, redirect)
+	}
+
+	return redirects, nil
+}
+
+func (s *ValkeyStore) scanSlugs(ctx context.Context) ([]string, error) {
+	var slugs []string
+	cursor := uint64(0)
+
+	for {
+		entry, err := s.client.Do(ctx, s.client.B().Scan().Cursor(cursor).Match("!*").Build()).AsScanEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		slugs = append(slugs, entry.Elements...)
+		cursor = entry.Cursor
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return slugs, nil
+}
+
+// Malformed scans every `!slug` key and reports the ones whose list is
+// missing the url/owner/max_clicks elements Create always writes, which
+// Lookup (and so All) silently skips rather than surfacing as a Redirect.
+func (s *ValkeyStore) Malformed(ctx context.Context) ([]string, error) {
+	slugs, err := s.scanSlugs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var malformed []string
+	for _, slug := range slugs {
+		fields, err := s.client.Do(ctx, s.client.B().Lrange().Key(slug).Start(0).Stop(-1).Build()).AsStrSlice()
+		if err != nil || len(fields) < 3 {
+			malformed = append(malformed, slug)
+		}
+	}
+
+	return malformed, nil
+}
+
+// Purge force-removes slug's key and click history without checking
+// ownership, since a malformed entry may have no recoverable owner to check
+// against.
+func (s *ValkeyStore) Purge(ctx context.Context, slug string) error {
+	return s.client.Do(ctx, s.client.B().Del().Key(slug).Key(clicksKey(slug)).Build()).Error()
+}
+
+// Restore re-creates a previously exported redirect. Its per-click history
+// isn't exported, so the restored lifetime click count starts back at zero.
+func (s *ValkeyStore) Restore(ctx context.Context, redirect Redirect) error {
+	maxClicks := int64(0)
+	if redirect.MaxClicks != nil {
+		maxClicks = *redirect.MaxClicks
+	}
+
+	err := s.client.Do(ctx, s.client.B().Rpush().Key(redirect.Slug).
+		Element(redirect.Url).Element(redirect.Owner).Element(fmt.Sprint(maxClicks)).Build()).Error()
+	if err != nil {
+		return err
+	}
+
+	if redirect.ExpiresAt != nil {
+		if err := s.client.Do(ctx, s.client.B().Expireat().Key(redirect.Slug).Timestamp(redirect.ExpiresAt.Unix()).Build()).Error(); err != nil {
+			return err
+		}
+	}
+
+	return s.client.Do(ctx, s.client.B().Sadd().Key(ownerKey(redirect.Owner)).Member(redirect.Slug).Build()).Error()
+}
+
+func (s *ValkeyStore) CreateUser(ctx context.Context, username, passwordHash string) error {
+	created, err := s.client.Do(ctx, s.client.B().Hsetnx().Key(userKey(username)).Field("password").Value(passwordHash).Build()).ToInt64()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return ErrUserExists
+	}
+
+	return nil
+}
+
+func (s *ValkeyStore) UserPasswordHash(ctx context.Context, username string) (string, error) {
+	hash, err := s.client.Do(ctx, s.client.B().Hget().Key(userKey(username)).Field("password").Build()).ToString()
+	if err != nil {
+		return "", ErrNotFound
+	}
+
+	return hash, nil
+}
+
+func (s *ValkeyStore) SetPasswordHash(ctx context.Context, username, passwordHash string) error {
+	return s.client.Do(ctx, s.client.B().Hset().Key(userKey(username)).FieldValue().FieldValue("password", passwordHash).Build()).Error()
+}
+
+func (s *ValkeyStore) DeleteUser(ctx context.Context, username string) error {
+	return s.client.Do(ctx, s.client.B().Del().Key(userKey(username)).Build()).Error()
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright © 2024 Oliver Pirger <0x4f48@proton.me>
+ *
+ * This program is free software: you can redistribute it and/or modify it under the terms of
+ * the GNU General Public License, version 3, as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+ * without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along with this program.
+ * If not, see <https://www.gnu.org/licenses/>.
+ *
+ * SPDX-License-Identifier: GPL-3.0-only
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// privateRanges are the address blocks ValidateURL rejects when
+// cfg.BlockPrivateHosts is set: loopback, link-local, RFC-1918 private space,
+// and the CGNAT range used by carrier-grade NAT.
+var privateRanges = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// ValidateURL checks that raw is a well-formed http(s) URL and, depending on
+// cfg, guards against it being used to redirect into internal infrastructure.
+// Host suffix lists are checked before any DNS lookup; the private-address
+// check only runs when cfg.BlockPrivateHosts is set, since it costs a lookup.
+func ValidateURL(ctx context.Context, cfg Config, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme must be http or https")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("url must have a host")
+	}
+
+	for _, suffix := range cfg.DeniedHostSuffixes {
+		if hostMatchesSuffix(host, suffix) {
+			return fmt.Errorf("host %q is denied", host)
+		}
+	}
+
+	if len(cfg.AllowedHostSuffixes) > 0 {
+		allowed := false
+		for _, suffix := range cfg.AllowedHostSuffixes {
+			if hostMatchesSuffix(host, suffix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("host %q is not in the allowlist", host)
+		}
+	}
+
+	if !cfg.BlockPrivateHosts {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve host %q: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+
+		for _, r := range privateRanges {
+			if r.Contains(ip) {
+				return fmt.Errorf("host %q resolves to a private address", host)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hostMatchesSuffix reports whether host equals suffix or is a subdomain of it.
+func hostMatchesSuffix(host, suffix string) bool {
+	host = strings.ToLower(host)
+	suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+
+	return host == suffix || strings.HasSuffix(host, "."+suffix)
+}